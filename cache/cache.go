@@ -0,0 +1,78 @@
+// Package cache provides a drive.Client which caches chunks on local disk,
+// to avoid refetching unchanged chunks from a (possibly remote) backend on
+// every read.
+package cache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"time"
+
+	"github.com/asjoyner/shade/drive"
+)
+
+// Reader is a drive.Client backed by another drive.Client, which caches the
+// chunks it reads (and writes) under a local disk directory.
+type Reader struct {
+	client drive.Client
+	dir    string
+}
+
+// NewReader returns a Reader backed by clients[0], caching chunks under a
+// temporary directory.  Additional entries in clients are currently
+// ignored; wrap multiple backends in a drive/manifold.Client first if you
+// want Reader to serve reads from all of them.
+func NewReader(clients []drive.Client, refresh *time.Ticker) (*Reader, error) {
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("cache.NewReader requires at least one client")
+	}
+	dir, err := ioutil.TempDir("", "shadeCache")
+	if err != nil {
+		return nil, fmt.Errorf("creating cache dir: %s", err)
+	}
+	return &Reader{client: clients[0], dir: dir}, nil
+}
+
+// GetConfig returns the Config of the wrapped client.
+func (r *Reader) GetConfig() drive.Config { return r.client.GetConfig() }
+
+// ListFiles returns the SHA-256 sums known to the wrapped client.
+func (r *Reader) ListFiles() ([][]byte, error) { return r.client.ListFiles() }
+
+// GetChunk returns the chunk addressed by sha256sum, serving it from the
+// local disk cache when present, and populating the cache on a miss.
+func (r *Reader) GetChunk(sha256sum []byte) ([]byte, error) {
+	p := r.path(sha256sum)
+	if chunk, err := ioutil.ReadFile(p); err == nil {
+		return chunk, nil
+	}
+	chunk, err := r.client.GetChunk(sha256sum)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(p, chunk, 0600); err != nil {
+		return chunk, nil // serve it anyway; the cache is just an optimization
+	}
+	return chunk, nil
+}
+
+// PutChunk writes chunk to the wrapped client and populates the local cache.
+func (r *Reader) PutChunk(sha256sum, chunk []byte) error {
+	if err := r.client.PutChunk(sha256sum, chunk); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path(sha256sum), chunk, 0600)
+}
+
+// PutFile writes fj to the wrapped client and populates the local cache.
+func (r *Reader) PutFile(sha256sum, fj []byte) error {
+	if err := r.client.PutFile(sha256sum, fj); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path(sha256sum), fj, 0600)
+}
+
+func (r *Reader) path(sha256sum []byte) string {
+	return path.Join(r.dir, fmt.Sprintf("%x", sha256sum))
+}
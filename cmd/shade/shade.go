@@ -8,20 +8,22 @@ import (
 	"os"
 	"os/signal"
 	"path"
-	"time"
 
-	"bazil.org/fuse"
+	"github.com/hanwen/go-fuse/v2/fuse"
 
 	"github.com/asjoyner/shade"
 	"github.com/asjoyner/shade/cache"
 	"github.com/asjoyner/shade/config"
 	"github.com/asjoyner/shade/drive"
+	"github.com/asjoyner/shade/drive/manifold"
 	"github.com/asjoyner/shade/fusefs"
 
 	_ "github.com/asjoyner/shade/drive/amazon"
+	_ "github.com/asjoyner/shade/drive/crypt"
 	_ "github.com/asjoyner/shade/drive/google"
 	_ "github.com/asjoyner/shade/drive/localdrive"
 	_ "github.com/asjoyner/shade/drive/memory"
+	_ "github.com/asjoyner/shade/drive/overlay"
 )
 
 var (
@@ -30,16 +32,32 @@ var (
 	readOnly   = flag.Bool("readonly", false, "Mount the filesystem read only.")
 	allowOther = flag.Bool("allow_other", false, "If other users are allowed to view the mounted filesystem.")
 	configFile = flag.String("config", defaultConfig, fmt.Sprintf("The shade config file. Defaults to %q", defaultConfig))
+	commit     = flag.Bool("commit", false, "With the \"unmount\" subcommand, promote an overlay provider's upper layer into its lower backend.")
 )
 
 func main() {
 	flag.Usage = usage
 	flag.Parse()
 
+	if flag.NArg() >= 1 && flag.Arg(0) == "unmount" {
+		// flag.Parse stops at the first non-flag argument, so flags given
+		// after the subcommand ("unmount --commit") are parsed here.
+		flag.CommandLine.Parse(flag.Args()[1:])
+		if flag.NArg() != 0 {
+			usage()
+			os.Exit(2)
+		}
+		if err := unmount(*configFile, *commit); err != nil {
+			log.Fatalf("unmount failed: %s\n", err)
+		}
+		return
+	}
+
 	if flag.NArg() != 1 {
 		usage()
 		os.Exit(2)
 	}
+	mountPoint := flag.Arg(0)
 
 	// read in the config
 	clients, err := config.Clients(*configFile)
@@ -47,85 +65,113 @@ func main() {
 		log.Fatalf("could not initialize clients: %s\n", err)
 	}
 
-	// Setup fuse FS
-	conn, err := mountFuse(flag.Arg(0))
+	// Wrap all configured backends in a single manifold.Client, so e.g. a
+	// localdrive cache tier and google/amazon durable replicas are served
+	// to the rest of shade as one drive.Client.
+	client, err := manifold.New(clients, 0)
 	if err != nil {
-		log.Fatalf("failed to mount: %s", err)
-	}
-	fmt.Printf("Mounting Shade FuseFS at %s...\n", flag.Arg(0))
-
-	if err := serviceFuse(conn, clients); err != nil {
-		log.Fatalf("failed to service mount: %s", err)
+		log.Fatalf("could not initialize manifold: %s\n", err)
 	}
 
-	return
-}
-
-func usage() {
-	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "  %s <mountpoint>\n", os.Args[0])
-	flag.PrintDefaults()
-}
-
-func mountFuse(mountPoint string) (*fuse.Conn, error) {
 	if err := sanityCheck(mountPoint); err != nil {
-		return nil, fmt.Errorf("sanityCheck failed: %s\n", err)
+		log.Fatalf("sanityCheck failed: %s\n", err)
 	}
 
-	options := []fuse.MountOption{
-		fuse.FSName("Shade"),
-		//fuse.Subtype(""),
-		//fuse.VolumeName(<iterate clients?>),
-	}
-
-	if *allowOther {
-		options = append(options, fuse.AllowOther())
-	}
-	if *readOnly {
-		options = append(options, fuse.ReadOnly())
-	}
-	options = append(options, fuse.NoAppleDouble())
-	c, err := fuse.Mount(mountPoint, options...)
+	server, err := serviceFuse(mountPoint, client)
 	if err != nil {
-		fmt.Println("Is the mount point busy?")
-		return nil, err
+		log.Fatalf("failed to mount: %s\n", err)
 	}
+	fmt.Printf("Mounting Shade FuseFS at %s...\n", mountPoint)
 
 	// Trap control-c (sig INT) and unmount
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt)
 	go func() {
 		for range sig {
-			if err := fuse.Unmount(mountPoint); err != nil {
-				log.Printf("fuse.Unmount failed: %v", err)
+			if err := server.Unmount(); err != nil {
+				log.Printf("unmount failed: %v", err)
 			}
 		}
 	}()
 
-	return c, nil
+	server.Wait()
 }
 
-// serviceFuse initializes fusefs, the shade implementation of a fuse file
-// server, and services requests from the fuse kernel filesystem until it is
-// unmounted.
-func serviceFuse(conn *fuse.Conn, clients []drive.Client) error {
-	refresh := time.NewTicker(5 * time.Minute)
-	r, err := cache.NewReader(clients, refresh)
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s <mountpoint>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s unmount [--commit]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// nonPersistentUpperProviders are "upper" backends --commit cannot work
+// against, because it constructs a brand new drive.Client in this process
+// rather than talking to the one already running inside the mount: their
+// state lives only in the mount process that created it (memory's is
+// in-process RAM; it has no on-disk handle for a second process to open),
+// so re-parsing the config here would "successfully" commit zero files
+// instead of erroring.
+var nonPersistentUpperProviders = map[string]bool{
+	"memory": true,
+}
+
+// unmount services the "unmount" subcommand.  With --commit, it finds the
+// configured "overlay" provider, if any, and promotes its upper layer's
+// files down into its lower backend; without it, it's currently a no-op,
+// since the fuse unmount itself is handled by sending the running shade
+// process SIGINT.
+func unmount(configFile string, commit bool) error {
+	if !commit {
+		return nil
+	}
+	configs, err := config.Read(configFile)
 	if err != nil {
-		return err
+		return fmt.Errorf("could not parse config: %s", err)
+	}
+	var overlayConfig *drive.Config
+	for i := range configs {
+		if configs[i].Provider == "overlay" {
+			overlayConfig = &configs[i]
+			break
+		}
+	}
+	if overlayConfig == nil {
+		return fmt.Errorf("no overlay provider configured in %q", configFile)
 	}
-	ffs := fusefs.New(r, conn)
-	err = ffs.Serve()
+	if overlayConfig.Upper == nil {
+		return fmt.Errorf("overlay provider in %q has no Upper configured", configFile)
+	}
+	if nonPersistentUpperProviders[overlayConfig.Upper.Provider] {
+		return fmt.Errorf("overlay Upper provider %q keeps its state in the mount process's memory; "+
+			"unmount --commit runs in a separate process and would see it empty. Use a persistent "+
+			"Upper backend (e.g. \"localdrive\") to use --commit", overlayConfig.Upper.Provider)
+	}
+	c, err := drive.NewClient(*overlayConfig)
 	if err != nil {
-		return fmt.Errorf("fuse server initialization failed: %s", err)
+		return fmt.Errorf("initializing overlay: %s", err)
+	}
+	committer, ok := c.(interface{ Commit() error })
+	if !ok {
+		return fmt.Errorf("overlay provider does not support --commit")
 	}
+	return committer.Commit()
+}
 
-	// check if the mount process has an error to report
-	<-conn.Ready
-	if err := conn.MountError; err != nil {
-		return err
+// serviceFuse initializes fusefs, the shade implementation of a fuse file
+// server, and mounts it at mountPoint.
+func serviceFuse(mountPoint string, client drive.Client) (*fuse.Server, error) {
+	r, err := cache.NewReader([]drive.Client{client}, nil)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	server, err := fusefs.New(r, mountPoint, fusefs.Options{
+		ReadOnly:   *readOnly,
+		AllowOther: *allowOther,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fuse server initialization failed: %s", err)
+	}
+	return server, nil
 }
 
 func sanityCheck(mountPoint string) error {
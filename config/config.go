@@ -34,13 +34,31 @@ func parseConfig(contents []byte) ([]drive.Config, error) {
 		return nil, fmt.Errorf("no provider in config file")
 	}
 	for _, config := range configs {
-		if !drive.ValidProvider(config.Provider) {
-			return nil, fmt.Errorf("unsupported provider in config: %q", config.Provider)
+		if err := validProvider(config); err != nil {
+			return nil, err
 		}
 	}
 	return configs, nil
 }
 
+// validProvider checks that c.Provider is registered, recursing into
+// c.Remote (e.g. "crypt") and c.Upper/c.Lower (e.g. "overlay") for wrapper
+// providers which are themselves backed by one or more nested Configs.
+func validProvider(c drive.Config) error {
+	if !drive.ValidProvider(c.Provider) {
+		return fmt.Errorf("unsupported provider in config: %q", c.Provider)
+	}
+	for _, nested := range []*drive.Config{c.Remote, c.Upper, c.Lower} {
+		if nested == nil {
+			continue
+		}
+		if err := validProvider(*nested); err != nil {
+			return fmt.Errorf("%s: %s", c.Provider, err)
+		}
+	}
+	return nil
+}
+
 func Clients(configFile string) ([]drive.Client, error) {
 	configs, err := Read(configFile)
 	if err != nil {
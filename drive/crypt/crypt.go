@@ -0,0 +1,240 @@
+// Package crypt implements a drive.Client which transparently encrypts
+// chunks and file metadata before handing them to another, nested
+// drive.Client (the "remote"), so the remote never sees plaintext.  It
+// mirrors rclone's crypt backend: it can wrap any other provider.
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/asjoyner/shade/drive"
+)
+
+func init() {
+	drive.Register("crypt", NewClient)
+}
+
+const (
+	saltSize = 32
+	keySize  = 32
+)
+
+// headerSha is the fixed, passphrase-independent address under which the
+// one-time salt is stored on the remote.
+var headerSha = sha256.Sum256([]byte("shade-crypt-header"))
+
+// Client wraps a nested drive.Client, encrypting every chunk and
+// shade.File it writes with AES-256-GCM before handing it to remote, and
+// decrypting what it reads back.
+//
+// Every operation is keyed off sha256sum, the plaintext SHA-256 sum shade
+// already addresses the chunk by, so Client carries no mutable state of
+// its own past initialization: the remote address a chunk is stored under
+// is HMAC(addrKey, sha256sum), deterministic and re-derivable by any
+// process sharing the same passphrase, so two crypt.Clients wrapping the
+// same remote never need to coordinate a shared manifest (and can't race
+// on one).
+type Client struct {
+	remote  drive.Client
+	config  drive.Config
+	gcm     cipher.AEAD
+	addrKey []byte
+}
+
+// NewClient initializes c.Remote and derives the encryption and
+// addressing keys from c.Passphrase, writing a fresh salt to the remote
+// on first use.
+func NewClient(c drive.Config) (drive.Client, error) {
+	if c.Remote == nil {
+		return nil, fmt.Errorf("crypt: Config.Remote is required")
+	}
+	if c.Passphrase == "" {
+		return nil, fmt.Errorf("crypt: Config.Passphrase is required")
+	}
+	remote, err := drive.NewClient(*c.Remote)
+	if err != nil {
+		return nil, fmt.Errorf("crypt: initializing remote %q: %s", c.Remote.Provider, err)
+	}
+	return newClient(c, remote)
+}
+
+// newClient builds a Client around an already-constructed remote,
+// deriving its keys from c.Passphrase.  It's split out from NewClient so
+// tests can exercise it against a fake drive.Client without registering
+// one with the package-wide drive.Register registry.
+func newClient(c drive.Config, remote drive.Client) (*Client, error) {
+	cl := &Client{remote: remote, config: c}
+
+	salt, err := cl.loadOrCreateSalt()
+	if err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key([]byte(c.Passphrase), salt, 1<<15, 8, 1, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("crypt: deriving key: %s", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("crypt: initializing cipher: %s", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("crypt: initializing GCM: %s", err)
+	}
+	cl.gcm = gcm
+	cl.addrKey = subkey(key, "address")
+	return cl, nil
+}
+
+// GetConfig returns the Config this Client was initialized with.
+func (c *Client) GetConfig() drive.Config { return c.config }
+
+// loadOrCreateSalt fetches the previously written salt from remote, or
+// generates and writes one if this is the first time this remote has been
+// used with drive/crypt.  It only treats drive.ErrNotFound as "no header
+// yet"; any other error (a network blip, a rate limit, ...) is propagated
+// rather than silently overwritten with a fresh salt, since that would
+// permanently strand every chunk already stored under the real one.
+func (c *Client) loadOrCreateSalt() ([]byte, error) {
+	salt, err := c.remote.GetChunk(headerSha[:])
+	switch {
+	case err == nil:
+		return salt, nil
+	case errors.Is(err, drive.ErrNotFound):
+		// first use of this remote; fall through and write one
+	default:
+		return nil, fmt.Errorf("crypt: checking for existing crypt-header: %s", err)
+	}
+	salt = make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("crypt: generating salt: %s", err)
+	}
+	if err := c.remote.PutChunk(headerSha[:], salt); err != nil {
+		return nil, fmt.Errorf("crypt: writing crypt-header: %s", err)
+	}
+	return salt, nil
+}
+
+// subkey derives a label-specific key from key via HMAC-SHA256, so the
+// encryption key and the remote-addressing key are independent even
+// though both come from the same passphrase.
+func subkey(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+// address returns the deterministic remote address sha256sum's ciphertext
+// is stored under.  It's a function of the (secret) addrKey and
+// sha256sum alone, so it never needs to be recorded anywhere: any
+// crypt.Client initialized with the same passphrase re-derives the same
+// address, and the remote, lacking addrKey, can't correlate it back to
+// sha256sum or to any other chunk sharing the same plaintext.
+func (c *Client) address(sha256sum []byte) []byte {
+	mac := hmac.New(sha256.New, c.addrKey)
+	mac.Write(sha256sum)
+	return mac.Sum(nil)
+}
+
+// encrypt seals sha256sum||plaintext, prefixing the result with a fresh
+// random nonce.  Embedding sha256sum in the sealed payload, rather than
+// using it to derive a deterministic nonce, lets ListFiles recover it by
+// decrypting alone, without first knowing what sha256sum a given remote
+// address corresponds to.
+func (c *Client) encrypt(sha256sum, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("crypt: generating nonce: %s", err)
+	}
+	payload := append(append([]byte(nil), sha256sum...), plaintext...)
+	return c.gcm.Seal(nonce, nonce, payload, nil), nil
+}
+
+// decrypt reverses encrypt, verifying the GCM tag, and splits the sealed
+// payload back into the plaintext SHA-256 sum it was stored under and the
+// plaintext itself.
+func (c *Client) decrypt(ciphertext []byte) (sha256sum, plaintext []byte, err error) {
+	if len(ciphertext) < c.gcm.NonceSize() {
+		return nil, nil, fmt.Errorf("crypt: ciphertext too short")
+	}
+	nonce, ct := ciphertext[:c.gcm.NonceSize()], ciphertext[c.gcm.NonceSize():]
+	payload, err := c.gcm.Open(nil, nonce, ct, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(payload) < sha256.Size {
+		return nil, nil, fmt.Errorf("crypt: truncated payload")
+	}
+	return payload[:sha256.Size], payload[sha256.Size:], nil
+}
+
+// PutChunk encrypts chunk, embedding sha256sum in the sealed payload, and
+// stores it on remote under the deterministic address sha256sum hashes
+// to.
+func (c *Client) PutChunk(sha256sum, chunk []byte) error {
+	return c.put(sha256sum, chunk, c.remote.PutChunk)
+}
+
+// PutFile encrypts the marshalled shade.File fj the same way PutChunk
+// encrypts a chunk.
+func (c *Client) PutFile(sha256sum, fj []byte) error {
+	return c.put(sha256sum, fj, c.remote.PutFile)
+}
+
+func (c *Client) put(sha256sum, data []byte, store func([]byte, []byte) error) error {
+	ct, err := c.encrypt(sha256sum, data)
+	if err != nil {
+		return err
+	}
+	return store(c.address(sha256sum), ct)
+}
+
+// GetChunk fetches the ciphertext stored at sha256sum's deterministic
+// address and decrypts it.  This serves both chunk reads and shade.File
+// reads, since shade addresses both the same way.
+func (c *Client) GetChunk(sha256sum []byte) ([]byte, error) {
+	ct, err := c.remote.GetChunk(c.address(sha256sum))
+	if err != nil {
+		return nil, err
+	}
+	gotSum, plaintext, err := c.decrypt(ct)
+	if err != nil {
+		return nil, fmt.Errorf("crypt: decrypting %x: %s", sha256sum, err)
+	}
+	if !bytes.Equal(gotSum, sha256sum) {
+		return nil, fmt.Errorf("crypt: %x resolved to a chunk sealed for %x", sha256sum, gotSum)
+	}
+	return plaintext, nil
+}
+
+// ListFiles fetches and decrypts every shade.File remote knows about, to
+// recover the plaintext SHA-256 sum each was embedded with (remote's own
+// addresses are unlinkable back to it).
+func (c *Client) ListFiles() ([][]byte, error) {
+	addrs, err := c.remote.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+	out := make([][]byte, 0, len(addrs))
+	for _, addr := range addrs {
+		ct, err := c.remote.GetChunk(addr)
+		if err != nil {
+			continue
+		}
+		sha256sum, _, err := c.decrypt(ct)
+		if err != nil {
+			continue
+		}
+		out = append(out, sha256sum)
+	}
+	return out, nil
+}
@@ -0,0 +1,168 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/asjoyner/shade/drive"
+)
+
+// fakeClient is a minimal in-memory drive.Client, sufficient to exercise
+// crypt without a real backend.  A miss returns drive.ErrNotFound, since
+// crypt's salt handling depends on being able to tell that apart from
+// other errors.
+type fakeClient struct {
+	mu     sync.Mutex
+	chunks map[string][]byte
+	files  map[string]bool
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{chunks: make(map[string][]byte), files: make(map[string]bool)}
+}
+
+func (c *fakeClient) GetConfig() drive.Config { return drive.Config{Provider: "fake"} }
+
+func (c *fakeClient) ListFiles() ([][]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var shas [][]byte
+	for k := range c.files {
+		shas = append(shas, []byte(k))
+	}
+	return shas, nil
+}
+
+func (c *fakeClient) GetChunk(sha256sum []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	chunk, ok := c.chunks[string(sha256sum)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %x", drive.ErrNotFound, sha256sum)
+	}
+	return chunk, nil
+}
+
+func (c *fakeClient) PutChunk(sha256sum, chunk []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.chunks[string(sha256sum)] = chunk
+	return nil
+}
+
+func (c *fakeClient) PutFile(sha256sum, fj []byte) error {
+	c.mu.Lock()
+	c.files[string(sha256sum)] = true
+	c.mu.Unlock()
+	return c.PutChunk(sha256sum, fj)
+}
+
+func newTestClient(t *testing.T, remote drive.Client) *Client {
+	t.Helper()
+	c, err := newClient(drive.Config{Provider: "crypt", Passphrase: "hunter2"}, remote)
+	if err != nil {
+		t.Fatalf("newClient: %s", err)
+	}
+	return c
+}
+
+// TestChunkRoundTrip verifies PutChunk/GetChunk round-trip a chunk, and
+// that the remote only ever sees ciphertext under an address that is not
+// the plaintext SHA-256 sum.
+func TestChunkRoundTrip(t *testing.T) {
+	remote := newFakeClient()
+	c := newTestClient(t, remote)
+
+	chunk := []byte("hello, crypt")
+	sum := sha256.Sum256(chunk)
+	if err := c.PutChunk(sum[:], chunk); err != nil {
+		t.Fatalf("PutChunk: %s", err)
+	}
+
+	if _, err := remote.GetChunk(sum[:]); err == nil {
+		t.Fatalf("remote should not store the chunk under its plaintext SHA-256 sum")
+	}
+	if ct, err := remote.GetChunk(c.address(sum[:])); err != nil {
+		t.Fatalf("remote does not have anything at the expected address: %s", err)
+	} else if bytes.Contains(ct, chunk) {
+		t.Fatalf("remote's copy contains the plaintext chunk unencrypted")
+	}
+
+	got, err := c.GetChunk(sum[:])
+	if err != nil {
+		t.Fatalf("GetChunk: %s", err)
+	}
+	if !bytes.Equal(got, chunk) {
+		t.Fatalf("GetChunk returned %q, want %q", got, chunk)
+	}
+}
+
+// TestFileRoundTripAndListFiles verifies PutFile/GetChunk round-trip a
+// shade.File's JSON, and that ListFiles recovers its plaintext SHA-256 sum
+// by decrypting, without any manifest to consult.
+func TestFileRoundTripAndListFiles(t *testing.T) {
+	remote := newFakeClient()
+	c := newTestClient(t, remote)
+
+	fj := []byte(`{"Filename":"a.txt"}`)
+	sum := sha256.Sum256(fj)
+	if err := c.PutFile(sum[:], fj); err != nil {
+		t.Fatalf("PutFile: %s", err)
+	}
+
+	shas, err := c.ListFiles()
+	if err != nil {
+		t.Fatalf("ListFiles: %s", err)
+	}
+	if len(shas) != 1 || !bytes.Equal(shas[0], sum[:]) {
+		t.Fatalf("ListFiles returned %x, want [%x]", shas, sum[:])
+	}
+
+	got, err := c.GetChunk(sum[:])
+	if err != nil {
+		t.Fatalf("GetChunk: %s", err)
+	}
+	if !bytes.Equal(got, fj) {
+		t.Fatalf("GetChunk returned %q, want %q", got, fj)
+	}
+}
+
+// TestSaltPersistsAcrossClients verifies a second Client wrapping the same
+// remote reuses the salt (and therefore the key and addresses) written by
+// the first, rather than generating its own and stranding the first
+// Client's chunks.
+func TestSaltPersistsAcrossClients(t *testing.T) {
+	remote := newFakeClient()
+	first := newTestClient(t, remote)
+
+	chunk := []byte("shared remote")
+	sum := sha256.Sum256(chunk)
+	if err := first.PutChunk(sum[:], chunk); err != nil {
+		t.Fatalf("PutChunk: %s", err)
+	}
+
+	second := newTestClient(t, remote)
+	got, err := second.GetChunk(sum[:])
+	if err != nil {
+		t.Fatalf("second Client could not read first Client's chunk: %s", err)
+	}
+	if !bytes.Equal(got, chunk) {
+		t.Fatalf("GetChunk returned %q, want %q", got, chunk)
+	}
+}
+
+// TestGetChunkUnknownSha verifies a sum nothing was ever written under
+// surfaces the remote's not-found error rather than panicking or
+// succeeding.
+func TestGetChunkUnknownSha(t *testing.T) {
+	remote := newFakeClient()
+	c := newTestClient(t, remote)
+
+	unknown := sha256.Sum256([]byte("never written"))
+	if _, err := c.GetChunk(unknown[:]); err == nil {
+		t.Fatalf("GetChunk should have failed for an unknown sum")
+	}
+}
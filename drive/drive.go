@@ -0,0 +1,147 @@
+// Package drive defines the interface shade uses to store and retrieve
+// chunks and file metadata, and the registry that maps the "provider"
+// field of a config file entry to the code which implements it.
+package drive
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotImplemented is returned by optional Client methods, such as
+// ManifestID, which a given backend does not support.
+var ErrNotImplemented = errors.New("not implemented")
+
+// ErrNotFound is returned by GetChunk when sha256sum is simply unknown to
+// the backend, as opposed to the lookup having failed for some other
+// reason (a network error, a rate limit, ...).  Callers that need to tell
+// "doesn't exist yet" apart from "couldn't check" (e.g. drive/crypt
+// deciding whether it's safe to write a fresh salt) should check for it
+// with errors.Is. Backends should wrap their own not-found condition with
+// it, e.g. `fmt.Errorf("%w: %x", drive.ErrNotFound, sha256sum)`.
+var ErrNotFound = errors.New("chunk not found")
+
+// Persistence describes how durable a backend is expected to be, so callers
+// composing several Clients together (see drive/manifold) know which ones
+// are safe to treat as the authoritative copy of the data and which are
+// merely fast local caches.
+type Persistence string
+
+const (
+	// Cache indicates the backend is a local, disposable copy of the data.
+	// It may be consulted first for reads, but should never be the only
+	// place a write lands.
+	Cache Persistence = "cache"
+	// Durable indicates the backend is an authoritative store; writes are
+	// not considered complete until they reach at least one Durable
+	// backend.
+	Durable Persistence = "durable"
+)
+
+// Config describes how to initialize a single storage provider.  It is
+// parsed directly from the user's config file, so existing field names and
+// types need to stay compatible with configs already on disk.
+type Config struct {
+	Provider      string
+	FileParentID  string
+	ChunkParentID string
+	MaxFiles      uint32
+	MaxChunkBytes uint64
+
+	// Persistence declares whether this backend is a cache tier or an
+	// authoritative store.  It defaults to Durable when unset, so existing
+	// single-backend configs keep their current behavior.
+	Persistence Persistence
+	// Priority orders backends within the same Persistence tier, lowest
+	// first.  drive/manifold sorts each tier by it, but sends reads and
+	// writes to every backend of a tier in parallel, so it does not make
+	// one backend answer or store before another.
+	Priority int
+
+	// Remote and Passphrase are used by wrapper providers, such as
+	// drive/crypt, which are themselves backed by another drive.Client
+	// instead of talking to a storage backend directly.
+	Remote     *Config
+	Passphrase string
+
+	// Upper and Lower are used by the "overlay" wrapper provider (see
+	// drive/overlay): Upper is a writable scratch backend consulted first,
+	// Lower is the read-only backend beneath it.
+	Upper *Config
+	Lower *Config
+
+	// EntryTimeout and AttrTimeout tell the fuse kernel client how long it
+	// may cache directory entries and inode attributes before checking
+	// back with fusefs.  They default to 1s (the usual fuse default) when
+	// unset.
+	EntryTimeout time.Duration
+	AttrTimeout  time.Duration
+}
+
+// Client is the interface a storage backend must implement to be used by
+// shade.  Filenames are never sent to a backend directly: the caller
+// marshals a shade.File to JSON, and the resulting bytes are stored and
+// retrieved like any other chunk, addressed by its SHA-256 sum.
+type Client interface {
+	// ListFiles returns the SHA-256 sum of every shade.File currently known
+	// to the backend.
+	ListFiles() ([][]byte, error)
+	// GetChunk retrieves the chunk (file metadata or file data) stored
+	// under sha256sum.
+	GetChunk(sha256sum []byte) ([]byte, error)
+	// PutChunk stores chunk under its SHA-256 sum.
+	PutChunk(sha256sum, chunk []byte) error
+	// PutFile stores the marshalled shade.File fj under its SHA-256 sum,
+	// sha256sum.
+	PutFile(sha256sum, fj []byte) error
+	// GetConfig returns the Config this client was initialized with.
+	GetConfig() Config
+}
+
+// ManifestIDer is an optional interface a Client may implement to report a
+// stable digest over its current (filename -> sha256) set, letting
+// Tree.Refresh (see fusefs) skip a full list-and-fetch cycle when nothing
+// has changed.  A Client which can't cheaply compute this (or hasn't been
+// taught to) should return ErrNotImplemented; Refresh falls back to its
+// usual ListFiles-driven path in that case.
+type ManifestIDer interface {
+	ManifestID() ([]byte, error)
+}
+
+// Deleter is an optional interface a Client may implement to be told a
+// filename has been removed, as opposed to merely forgetting the Node
+// locally.  drive/overlay implements this to persist a tombstone to its
+// upper layer; fusefs calls it, when present, on Unlink.
+type Deleter interface {
+	Delete(filename string) error
+}
+
+// NewClientFunc initializes a Client from a Config.  Each provider package
+// registers one via Register in its init().
+type NewClientFunc func(Config) (Client, error)
+
+var providers = make(map[string]NewClientFunc)
+
+// Register makes a provider available to NewClient and ValidProvider under
+// name.  It is meant to be called from the init() function of a package
+// implementing Client.
+func Register(name string, f NewClientFunc) {
+	providers[name] = f
+}
+
+// ValidProvider returns true if name has been registered by a provider
+// package.
+func ValidProvider(name string) bool {
+	_, ok := providers[name]
+	return ok
+}
+
+// NewClient initializes and returns the Client described by c.
+func NewClient(c Config) (Client, error) {
+	f, ok := providers[c.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider: %q", c.Provider)
+	}
+	return f(c)
+}
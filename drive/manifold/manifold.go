@@ -0,0 +1,213 @@
+// Package manifold implements a drive.Client which fans out across a set of
+// other drive.Clients, letting a single shade mount mix fast local caches
+// with slower, durable, off-site backends.
+package manifold
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/asjoyner/shade/drive"
+)
+
+// retries is the number of attempts made against a single backend before
+// its write is considered failed.
+const retries = 3
+
+// Client satisfies drive.Client by wrapping a slice of other drive.Clients.
+// Writes fan out to every Durable backend and must succeed on all of them
+// (or on a configurable N of them, see New), while Cache backends are
+// treated as a local
+// read-through layer: GetChunk races them ahead of the Durable backends,
+// and a cache miss there is not itself an error.
+type Client struct {
+	caches  []drive.Client // drive.Cache, ordered by Priority
+	durable []drive.Client // drive.Durable, ordered by Priority
+	// replication is how many Durable backends a write must succeed
+	// against.  Zero means "all of them".
+	replication int
+	config      drive.Config
+}
+
+// New returns a Client which fans writes out across clients and races reads
+// against them, according to each Config's Persistence and Priority.  A
+// replication of 0 requires a write to succeed against every Durable
+// backend; a positive value requires only that many.
+func New(clients []drive.Client, replication int) (*Client, error) {
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("manifold requires at least one backend")
+	}
+	c := &Client{replication: replication, config: drive.Config{Provider: "manifold"}}
+	for _, cl := range clients {
+		conf := cl.GetConfig()
+		if c.config.EntryTimeout == 0 {
+			c.config.EntryTimeout = conf.EntryTimeout
+		}
+		if c.config.AttrTimeout == 0 {
+			c.config.AttrTimeout = conf.AttrTimeout
+		}
+		if conf.Persistence == drive.Cache {
+			c.caches = append(c.caches, cl)
+		} else {
+			c.durable = append(c.durable, cl)
+		}
+	}
+	if len(c.durable) == 0 {
+		return nil, fmt.Errorf("manifold requires at least one durable backend")
+	}
+	byPriority := func(s []drive.Client) func(i, j int) bool {
+		return func(i, j int) bool { return s[i].GetConfig().Priority < s[j].GetConfig().Priority }
+	}
+	sort.Slice(c.caches, byPriority(c.caches))
+	sort.Slice(c.durable, byPriority(c.durable))
+	if c.replication <= 0 || c.replication > len(c.durable) {
+		c.replication = len(c.durable)
+	}
+	return c, nil
+}
+
+// GetConfig returns a synthetic Config describing the manifold itself.  Its
+// EntryTimeout and AttrTimeout are the first non-zero values found among
+// the wrapped clients' Configs, in the order given to New.
+func (c *Client) GetConfig() drive.Config { return c.config }
+
+// GetChunk races the cache tiers first, falling back to racing the
+// durable backends if none of the caches have sha256sum.
+func (c *Client) GetChunk(sha256sum []byte) ([]byte, error) {
+	if chunk, err := raceGetChunk(c.caches, sha256sum); err == nil {
+		return chunk, nil
+	}
+	return raceGetChunk(c.durable, sha256sum)
+}
+
+// raceGetChunk fires GetChunk at every client in parallel and returns the
+// first successful response.
+func raceGetChunk(clients []drive.Client, sha256sum []byte) ([]byte, error) {
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("no backends to query")
+	}
+	type result struct {
+		chunk []byte
+		err   error
+	}
+	results := make(chan result, len(clients))
+	var wg sync.WaitGroup
+	for _, cl := range clients {
+		wg.Add(1)
+		go func(cl drive.Client) {
+			defer wg.Done()
+			chunk, err := cl.GetChunk(sha256sum)
+			results <- result{chunk, err}
+		}(cl)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	var lastErr error
+	for r := range results {
+		if r.err == nil {
+			return r.chunk, nil
+		}
+		lastErr = r.err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("chunk not found: %x", sha256sum)
+	}
+	return nil, lastErr
+}
+
+// PutChunk writes chunk to every cache tier, and to at least c.replication
+// of the durable backends.
+func (c *Client) PutChunk(sha256sum, chunk []byte) error {
+	return c.put(sha256sum, chunk, func(cl drive.Client) error {
+		return cl.PutChunk(sha256sum, chunk)
+	})
+}
+
+// PutFile writes fj to every cache tier, and to at least c.replication of
+// the durable backends.
+func (c *Client) PutFile(sha256sum, fj []byte) error {
+	return c.put(sha256sum, fj, func(cl drive.Client) error {
+		return cl.PutFile(sha256sum, fj)
+	})
+}
+
+// put fans write out, in parallel, to every cache tier (best effort) and to
+// every durable backend, of which c.replication must succeed, trying each
+// individual backend up to `retries` times before giving up on it.
+func (c *Client) put(sha256sum, data []byte, do func(drive.Client) error) error {
+	var wg sync.WaitGroup
+	for _, cl := range c.caches {
+		wg.Add(1)
+		go func(cl drive.Client) {
+			defer wg.Done()
+			putWithRetry(cl, do)
+		}(cl)
+	}
+
+	errs := make(chan error, len(c.durable))
+	for _, cl := range c.durable {
+		wg.Add(1)
+		go func(cl drive.Client) {
+			defer wg.Done()
+			errs <- putWithRetry(cl, do)
+		}(cl)
+	}
+	wg.Wait()
+	close(errs)
+
+	var failures []error
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		failures = append(failures, err)
+	}
+	if succeeded < c.replication {
+		return fmt.Errorf("only %d/%d durable writes of %x succeeded: %v", succeeded, c.replication, sha256sum, failures)
+	}
+	return nil
+}
+
+func putWithRetry(cl drive.Client, do func(drive.Client) error) error {
+	var err error
+	for i := 0; i < retries; i++ {
+		if err = do(cl); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %s", cl.GetConfig().Provider, err)
+}
+
+// ListFiles merges the results of ListFiles from every backend, deduping by
+// SHA-256 sum.
+func (c *Client) ListFiles() ([][]byte, error) {
+	seen := make(map[string]bool)
+	var merged [][]byte
+	var errs []error
+	for _, cl := range append(append([]drive.Client{}, c.caches...), c.durable...) {
+		shas, err := cl.ListFiles()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", cl.GetConfig().Provider, err))
+			continue
+		}
+		for _, sha := range shas {
+			key := string(sha)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			merged = append(merged, sha)
+		}
+	}
+	if len(merged) == 0 && len(errs) > 0 {
+		return nil, fmt.Errorf("all backends failed: %v", errs)
+	}
+	sort.Slice(merged, func(i, j int) bool { return bytes.Compare(merged[i], merged[j]) < 0 })
+	return merged, nil
+}
@@ -0,0 +1,162 @@
+package manifold
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/asjoyner/shade/drive"
+	"github.com/asjoyner/shade/drive/drivetest"
+)
+
+// fakeClient is a minimal in-memory drive.Client, sufficient to exercise
+// manifold without a real backend.
+type fakeClient = drivetest.FakeClient
+
+func newFakeClient(persistence drive.Persistence, priority int) *fakeClient {
+	return drivetest.NewFakeClientWithConfig(drive.Config{Provider: "fake", Persistence: persistence, Priority: priority})
+}
+
+// TestPutChunkReplicatesToAllDurableByDefault verifies that a replication
+// of 0 (the manifold.New default) requires every durable backend to
+// receive the write, and every cache tier too.
+func TestPutChunkReplicatesToAllDurableByDefault(t *testing.T) {
+	cache := newFakeClient(drive.Cache, 0)
+	d1 := newFakeClient(drive.Durable, 0)
+	d2 := newFakeClient(drive.Durable, 1)
+	c, err := New([]drive.Client{cache, d1, d2}, 0)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+
+	chunk := []byte("payload")
+	sum := sha256.Sum256(chunk)
+	if err := c.PutChunk(sum[:], chunk); err != nil {
+		t.Fatalf("PutChunk: %s", err)
+	}
+	for name, cl := range map[string]*fakeClient{"cache": cache, "d1": d1, "d2": d2} {
+		if _, err := cl.GetChunk(sum[:]); err != nil {
+			t.Errorf("%s did not receive the write: %s", name, err)
+		}
+	}
+}
+
+// TestPutChunkSucceedsWithPartialReplication verifies that a replication
+// count less than the number of durable backends only requires that many
+// writes to succeed, tolerating failures on the rest.
+func TestPutChunkSucceedsWithPartialReplication(t *testing.T) {
+	d1 := newFakeClient(drive.Durable, 0)
+	d2 := newFakeClient(drive.Durable, 1)
+	d2.Fail = true
+	c, err := New([]drive.Client{d1, d2}, 1)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+
+	chunk := []byte("payload")
+	sum := sha256.Sum256(chunk)
+	if err := c.PutChunk(sum[:], chunk); err != nil {
+		t.Fatalf("PutChunk should succeed with 1/2 durable backends up: %s", err)
+	}
+	if _, err := d1.GetChunk(sum[:]); err != nil {
+		t.Errorf("surviving durable backend did not receive the write: %s", err)
+	}
+}
+
+// TestPutChunkFailsBelowReplicationThreshold verifies that PutChunk
+// returns an error when fewer than the required number of durable writes
+// succeed.
+func TestPutChunkFailsBelowReplicationThreshold(t *testing.T) {
+	d1 := newFakeClient(drive.Durable, 0)
+	d1.Fail = true
+	d2 := newFakeClient(drive.Durable, 1)
+	d2.Fail = true
+	c, err := New([]drive.Client{d1, d2}, 2)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+
+	chunk := []byte("payload")
+	sum := sha256.Sum256(chunk)
+	if err := c.PutChunk(sum[:], chunk); err == nil {
+		t.Fatalf("PutChunk should have failed: both durable backends are down")
+	}
+}
+
+// TestGetChunkPrefersCacheOverDurable verifies that GetChunk races the
+// cache tier first, and only falls back to the durable backends when no
+// cache has the chunk.
+func TestGetChunkPrefersCacheOverDurable(t *testing.T) {
+	cache := newFakeClient(drive.Cache, 0)
+	durable := newFakeClient(drive.Durable, 0)
+	c, err := New([]drive.Client{cache, durable}, 0)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+
+	chunk := []byte("payload")
+	sum := sha256.Sum256(chunk)
+	if err := durable.PutChunk(sum[:], chunk); err != nil {
+		t.Fatalf("PutChunk: %s", err)
+	}
+
+	got, err := c.GetChunk(sum[:])
+	if err != nil {
+		t.Fatalf("GetChunk: %s", err)
+	}
+	if !bytes.Equal(got, chunk) {
+		t.Fatalf("GetChunk returned %q, want %q", got, chunk)
+	}
+
+	// Now put a different payload under the same sum directly in cache, to
+	// prove cache wins the race rather than it just being the only copy.
+	if err := cache.PutChunk(sum[:], []byte("cached-copy")); err != nil {
+		t.Fatalf("PutChunk: %s", err)
+	}
+	got, err = c.GetChunk(sum[:])
+	if err != nil {
+		t.Fatalf("GetChunk: %s", err)
+	}
+	if !bytes.Equal(got, []byte("cached-copy")) {
+		t.Fatalf("GetChunk returned %q, want the cache tier's copy", got)
+	}
+}
+
+// TestListFilesDedupes verifies that ListFiles merges results across
+// backends, returning each SHA-256 sum only once even though it's present
+// on more than one client.
+func TestListFilesDedupes(t *testing.T) {
+	cache := newFakeClient(drive.Cache, 0)
+	durable := newFakeClient(drive.Durable, 0)
+	c, err := New([]drive.Client{cache, durable}, 0)
+	if err != nil {
+		t.Fatalf("New: %s", err)
+	}
+
+	fj := []byte("file json")
+	sum := sha256.Sum256(fj)
+	if err := cache.PutFile(sum[:], fj); err != nil {
+		t.Fatalf("PutFile: %s", err)
+	}
+	if err := durable.PutFile(sum[:], fj); err != nil {
+		t.Fatalf("PutFile: %s", err)
+	}
+
+	shas, err := c.ListFiles()
+	if err != nil {
+		t.Fatalf("ListFiles: %s", err)
+	}
+	if len(shas) != 1 {
+		t.Fatalf("ListFiles returned %d sha(s), want 1 (deduped): %x", len(shas), shas)
+	}
+}
+
+// TestNewRequiresAtLeastOneDurableBackend verifies New rejects a set of
+// clients with no Durable backend, since manifold needs at least one
+// authoritative store to write to.
+func TestNewRequiresAtLeastOneDurableBackend(t *testing.T) {
+	cache := newFakeClient(drive.Cache, 0)
+	if _, err := New([]drive.Client{cache}, 0); err == nil {
+		t.Fatalf("New should have rejected a cache-only backend set")
+	}
+}
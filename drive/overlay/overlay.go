@@ -0,0 +1,190 @@
+// Package overlay implements a drive.Client that mounts a writable "upper"
+// Client on top of a read-only "lower" Client, so a user can experiment
+// locally without risking their canonical remote copy.  It is modeled on
+// the union/overlay filesystem pattern (and the now-deprecated go-fuse
+// unionfs example): reads and directory listings consult upper first, then
+// fall back to lower; writes always land in upper; and a deletion of a
+// file that still exists in lower is recorded as a tombstone rather than
+// actually removing anything from lower.
+package overlay
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/asjoyner/shade"
+	"github.com/asjoyner/shade/drive"
+)
+
+func init() {
+	drive.Register("overlay", NewClient)
+}
+
+// Client satisfies drive.Client by layering upper over lower.
+type Client struct {
+	upper  drive.Client
+	lower  drive.Client
+	config drive.Config
+}
+
+var (
+	_ drive.Client  = (*Client)(nil)
+	_ drive.Deleter = (*Client)(nil)
+)
+
+// NewClient initializes c.Upper and c.Lower.
+func NewClient(c drive.Config) (drive.Client, error) {
+	if c.Upper == nil {
+		return nil, fmt.Errorf("overlay: Config.Upper is required")
+	}
+	if c.Lower == nil {
+		return nil, fmt.Errorf("overlay: Config.Lower is required")
+	}
+	upper, err := drive.NewClient(*c.Upper)
+	if err != nil {
+		return nil, fmt.Errorf("overlay: initializing upper %q: %s", c.Upper.Provider, err)
+	}
+	lower, err := drive.NewClient(*c.Lower)
+	if err != nil {
+		return nil, fmt.Errorf("overlay: initializing lower %q: %s", c.Lower.Provider, err)
+	}
+	return &Client{upper: upper, lower: lower, config: c}, nil
+}
+
+// GetConfig returns the Config this Client was initialized with.
+func (c *Client) GetConfig() drive.Config { return c.config }
+
+// GetChunk tries upper first, since it may hold a copied-up or newly
+// written chunk lower has never seen, then falls back to lower.
+func (c *Client) GetChunk(sha256sum []byte) ([]byte, error) {
+	if chunk, err := c.upper.GetChunk(sha256sum); err == nil {
+		return chunk, nil
+	}
+	return c.lower.GetChunk(sha256sum)
+}
+
+// PutChunk always writes to upper; lower is treated as read-only.
+func (c *Client) PutChunk(sha256sum, chunk []byte) error {
+	return c.upper.PutChunk(sha256sum, chunk)
+}
+
+// PutFile always writes to upper; lower is treated as read-only.
+func (c *Client) PutFile(sha256sum, fj []byte) error {
+	return c.upper.PutFile(sha256sum, fj)
+}
+
+// Delete records filename as removed by writing a tombstone shade.File (one
+// with Deleted set and no Chunks) to upper.  It satisfies drive.Deleter;
+// fusefs calls it on Unlink so the removal survives a later Refresh, which
+// would otherwise find filename still present in lower and resurrect it.
+func (c *Client) Delete(filename string) error {
+	f := &shade.File{Filename: filename, ModifiedTime: time.Now(), Deleted: true}
+	fj, err := f.ToJSON()
+	if err != nil {
+		return fmt.Errorf("overlay: marshaling tombstone for %q: %s", filename, err)
+	}
+	sum := sha256.Sum256(fj)
+	return c.upper.PutFile(sum[:], fj)
+}
+
+// ListFiles returns the shade.File sums that should be visible: every
+// non-tombstoned file named in upper, plus every file named only in
+// lower, each counted once even though a file's SHA-256 sum differs between
+// the two layers whenever its content or metadata differs.  A file named in
+// both layers is shadowed by upper's copy, tombstone or not.
+func (c *Client) ListFiles() ([][]byte, error) {
+	upperShas, err := c.upper.ListFiles()
+	if err != nil {
+		return nil, fmt.Errorf("overlay: listing upper: %s", err)
+	}
+	lowerShas, err := c.lower.ListFiles()
+	if err != nil {
+		return nil, fmt.Errorf("overlay: listing lower: %s", err)
+	}
+
+	type upperEntry struct {
+		sha     []byte
+		deleted bool
+	}
+	byName := make(map[string]upperEntry, len(upperShas))
+	for _, sha := range upperShas {
+		f, err := c.readFile(c.upper, sha)
+		if err != nil {
+			continue
+		}
+		byName[f.Filename] = upperEntry{sha: sha, deleted: f.Deleted}
+	}
+
+	var out [][]byte
+	for _, e := range byName {
+		if e.deleted {
+			continue
+		}
+		out = append(out, e.sha)
+	}
+	for _, sha := range lowerShas {
+		f, err := c.readFile(c.lower, sha)
+		if err != nil {
+			continue
+		}
+		if _, shadowed := byName[f.Filename]; shadowed {
+			continue
+		}
+		out = append(out, sha)
+	}
+	return out, nil
+}
+
+func (c *Client) readFile(client drive.Client, sha []byte) (*shade.File, error) {
+	fj, err := client.GetChunk(sha)
+	if err != nil {
+		return nil, err
+	}
+	f := &shade.File{}
+	if err := f.FromJSON(fj); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Commit copies every non-tombstoned shade.File known to upper, and the
+// chunks it references, down into lower.  It is the backend for `shade
+// unmount --commit`: it lets a user promote the changes they made in an
+// overlay session into the durable backend underneath it.  It does not
+// modify or clear upper.
+func (c *Client) Commit() error {
+	shas, err := c.upper.ListFiles()
+	if err != nil {
+		return fmt.Errorf("overlay: listing upper: %s", err)
+	}
+	for _, sha := range shas {
+		fj, err := c.upper.GetChunk(sha)
+		if err != nil {
+			return fmt.Errorf("overlay: reading upper file %x: %s", sha, err)
+		}
+		f := &shade.File{}
+		if err := f.FromJSON(fj); err != nil {
+			// Same tolerance ListFiles/readFile already apply elsewhere in
+			// this file: a sha that doesn't decode as a shade.File isn't
+			// one of ours to promote.
+			continue
+		}
+		if f.Deleted {
+			continue
+		}
+		for _, chunkSha := range f.Chunks {
+			chunk, err := c.upper.GetChunk(chunkSha)
+			if err != nil {
+				return fmt.Errorf("overlay: reading chunk %x of %q: %s", chunkSha, f.Filename, err)
+			}
+			if err := c.lower.PutChunk(chunkSha, chunk); err != nil {
+				return fmt.Errorf("overlay: writing chunk %x of %q: %s", chunkSha, f.Filename, err)
+			}
+		}
+		if err := c.lower.PutFile(sha, fj); err != nil {
+			return fmt.Errorf("overlay: writing file %q: %s", f.Filename, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package overlay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/asjoyner/shade"
+	"github.com/asjoyner/shade/drive"
+	"github.com/asjoyner/shade/drive/drivetest"
+)
+
+// newFakeClient returns a minimal in-memory drive.Client, sufficient to
+// exercise overlay without a real backend.
+func newFakeClient() *drivetest.FakeClient {
+	return drivetest.NewFakeClient()
+}
+
+func putFile(t *testing.T, c drive.Client, f *shade.File) []byte {
+	t.Helper()
+	fj, err := f.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON: %s", err)
+	}
+	sum := sha256.Sum256(fj)
+	if err := c.PutFile(sum[:], fj); err != nil {
+		t.Fatalf("PutFile: %s", err)
+	}
+	return sum[:]
+}
+
+// TestListFilesShadowsLower verifies that a file present in both layers is
+// represented only once, by upper's copy, and that a file present only in
+// lower still appears.
+func TestListFilesShadowsLower(t *testing.T) {
+	upper, lower := newFakeClient(), newFakeClient()
+	c := &Client{upper: upper, lower: lower}
+
+	putFile(t, lower, &shade.File{Filename: "both.txt", ModifiedTime: time.Unix(0, 0)})
+	putFile(t, upper, &shade.File{Filename: "both.txt", ModifiedTime: time.Unix(1, 0)})
+	lowerOnlySha := putFile(t, lower, &shade.File{Filename: "lower-only.txt", ModifiedTime: time.Unix(0, 0)})
+
+	shas, err := c.ListFiles()
+	if err != nil {
+		t.Fatalf("ListFiles: %s", err)
+	}
+	names := make(map[string]bool)
+	for _, sha := range shas {
+		f, err := c.readFile(upper, sha)
+		if err != nil {
+			f, err = c.readFile(lower, sha)
+		}
+		if err != nil {
+			t.Fatalf("could not read returned sha %x from either layer", sha)
+		}
+		names[f.Filename] = true
+	}
+	if len(shas) != 2 {
+		t.Fatalf("ListFiles returned %d sha(s), want 2: %v", len(shas), names)
+	}
+	if !names["both.txt"] || !names["lower-only.txt"] {
+		t.Fatalf("ListFiles missing expected filename(s): %v", names)
+	}
+	found := false
+	for _, sha := range shas {
+		if bytes.Equal(sha, lowerOnlySha) {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("lower-only.txt's sha not present in ListFiles result")
+	}
+}
+
+// TestDeleteTombstonesLowerFile verifies that deleting a file which exists
+// in lower removes it from ListFiles without touching lower.
+func TestDeleteTombstonesLowerFile(t *testing.T) {
+	upper, lower := newFakeClient(), newFakeClient()
+	c := &Client{upper: upper, lower: lower}
+
+	putFile(t, lower, &shade.File{Filename: "doomed.txt", ModifiedTime: time.Unix(0, 0)})
+
+	if err := c.Delete("doomed.txt"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	shas, err := c.ListFiles()
+	if err != nil {
+		t.Fatalf("ListFiles: %s", err)
+	}
+	for _, sha := range shas {
+		f, err := c.readFile(upper, sha)
+		if err != nil {
+			f, err = c.readFile(lower, sha)
+		}
+		if err != nil {
+			continue
+		}
+		if f.Filename == "doomed.txt" {
+			t.Fatalf("deleted file still present in ListFiles")
+		}
+	}
+	if lowerShas, _ := lower.ListFiles(); len(lowerShas) != 1 {
+		t.Fatalf("Delete should not modify lower, but lower now has %d file(s)", len(lowerShas))
+	}
+}
+
+// TestCommitPromotesUpperToLower verifies that Commit copies a non-deleted
+// upper file and its chunk down into lower, and skips tombstones.
+func TestCommitPromotesUpperToLower(t *testing.T) {
+	upper, lower := newFakeClient(), newFakeClient()
+	c := &Client{upper: upper, lower: lower}
+
+	chunk := []byte("payload")
+	chunkSum := sha256.Sum256(chunk)
+	if err := upper.PutChunk(chunkSum[:], chunk); err != nil {
+		t.Fatalf("PutChunk: %s", err)
+	}
+	putFile(t, upper, &shade.File{Filename: "promoted.txt", ModifiedTime: time.Unix(0, 0), Chunks: [][]byte{chunkSum[:]}})
+	if err := c.Delete("never-existed.txt"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+
+	if err := c.Commit(); err != nil {
+		t.Fatalf("Commit: %s", err)
+	}
+
+	lowerShas, err := lower.ListFiles()
+	if err != nil {
+		t.Fatalf("ListFiles: %s", err)
+	}
+	var gotPromoted, gotTombstone bool
+	for _, sha := range lowerShas {
+		f, err := c.readFile(lower, sha)
+		if err != nil {
+			t.Fatalf("readFile: %s", err)
+		}
+		switch f.Filename {
+		case "promoted.txt":
+			gotPromoted = true
+		case "never-existed.txt":
+			gotTombstone = true
+		}
+	}
+	if !gotPromoted {
+		t.Fatalf("Commit did not promote promoted.txt into lower")
+	}
+	if gotTombstone {
+		t.Fatalf("Commit should not promote tombstones into lower")
+	}
+	if _, err := lower.GetChunk(chunkSum[:]); err != nil {
+		t.Fatalf("Commit did not copy promoted.txt's chunk into lower: %s", err)
+	}
+}
@@ -0,0 +1,33 @@
+package shade
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// File describes a file stored in shade.  It is marshalled to JSON and
+// that JSON is what is actually given to a drive.Client to store; the
+// SHA-256 sum of the marshalled JSON is the file's identity in the Tree.
+type File struct {
+	Filename     string
+	Filesize     int64
+	ModifiedTime time.Time
+	// Chunks is the ordered list of SHA-256 sums of the chunks which make
+	// up the file's contents.
+	Chunks [][]byte
+	// Deleted marks this File as a tombstone: a record that Filename was
+	// removed, rather than a file with no content.  drive/overlay writes
+	// these to its upper layer so a deletion of a file that still exists
+	// in the lower layer sticks.
+	Deleted bool
+}
+
+// ToJSON marshals the File to JSON.
+func (f *File) ToJSON() ([]byte, error) {
+	return json.Marshal(f)
+}
+
+// FromJSON unmarshals JSON into the File.
+func (f *File) FromJSON(b []byte) error {
+	return json.Unmarshal(b, f)
+}
@@ -0,0 +1,73 @@
+package fusefs
+
+import (
+	"fmt"
+
+	"github.com/asjoyner/shade/drive"
+)
+
+// maxPutAttempts is how many times a single PutChunk/PutFile call is
+// attempted against client before the write is reported as failed.
+const maxPutAttempts = 3
+
+// batcher coalesces PutChunk/PutFile calls from many concurrent fuse
+// writers into a bounded number of parallel uploads, so a burst of writes
+// can't open an unbounded number of outstanding requests against client.
+type batcher struct {
+	client drive.Client
+	jobs   chan putJob
+}
+
+type putJob struct {
+	sha256sum []byte
+	data      []byte
+	isFile    bool
+	done      chan error
+}
+
+// newBatcher starts parallelism workers pulling from a shared job queue.
+func newBatcher(client drive.Client, parallelism int) *batcher {
+	b := &batcher{client: client, jobs: make(chan putJob, 64)}
+	for i := 0; i < parallelism; i++ {
+		go b.worker()
+	}
+	return b
+}
+
+func (b *batcher) worker() {
+	for j := range b.jobs {
+		j.done <- b.put(j)
+	}
+}
+
+func (b *batcher) put(j putJob) error {
+	var err error
+	for attempt := 0; attempt < maxPutAttempts; attempt++ {
+		if j.isFile {
+			err = b.client.PutFile(j.sha256sum, j.data)
+		} else {
+			err = b.client.PutChunk(j.sha256sum, j.data)
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %s", b.client.GetConfig().Provider, err)
+}
+
+// putChunk enqueues chunk and blocks until it has been durably written, or
+// permanently failed after maxPutAttempts attempts.
+func (b *batcher) putChunk(sha256sum, chunk []byte) error {
+	done := make(chan error, 1)
+	b.jobs <- putJob{sha256sum: sha256sum, data: chunk, done: done}
+	return <-done
+}
+
+// putFile enqueues fj, the marshalled shade.File, and blocks until it has
+// been durably written, or permanently failed after maxPutAttempts
+// attempts.
+func (b *batcher) putFile(sha256sum, fj []byte) error {
+	done := make(chan error, 1)
+	b.jobs <- putJob{sha256sum: sha256sum, data: fj, isFile: true, done: done}
+	return <-done
+}
@@ -0,0 +1,55 @@
+package fusefs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/asjoyner/shade"
+)
+
+func shaSum(b []byte) []byte {
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
+
+// populateSyntheticTree writes n files, spread across 100 directories, to
+// client, and returns the Root backed by it.
+func populateSyntheticTree(b *testing.B, n int) (*Root, string) {
+	client := newFakeClient()
+	const dirs = 100
+	for i := 0; i < n; i++ {
+		f := &shade.File{
+			Filename:     fmt.Sprintf("dir%d/file%d", i%dirs, i),
+			Filesize:     0,
+			ModifiedTime: time.Unix(0, 0),
+		}
+		fj, err := f.ToJSON()
+		if err != nil {
+			b.Fatalf("ToJSON: %s", err)
+		}
+		sum := shaSum(fj)
+		if err := client.PutFile(sum, fj); err != nil {
+			b.Fatalf("PutFile: %s", err)
+		}
+	}
+	return newTestRoot(b, client)
+}
+
+// BenchmarkReaddirPlus measures listing a directory's ~1,000 entries in a
+// synthetic 100k-file tree.  go-fuse answers ReadDirPlus straight from
+// Readdir (see node.go's readdir), so this single call is the entire cost
+// of a "ls -l"-style listing: one round trip per directory, rather than
+// one Readdir plus one Lookup/Getattr round trip per entry.
+func BenchmarkReaddirPlus(b *testing.B) {
+	root, scratchDir := populateSyntheticTree(b, 100000)
+	defer removeDir(scratchDir)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, errno := readdir(root, "dir0"); errno != 0 {
+			b.Fatalf("readdir: errno %d", errno)
+		}
+	}
+}
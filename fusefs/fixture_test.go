@@ -0,0 +1,45 @@
+package fusefs
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+
+	"github.com/asjoyner/shade/drive"
+	"github.com/asjoyner/shade/drive/drivetest"
+)
+
+// fakeClient is a minimal in-memory drive.Client, sufficient to exercise
+// fusefs without a real backend.
+type fakeClient = drivetest.FakeClient
+
+func newFakeClient() *fakeClient {
+	return drivetest.NewFakeClient()
+}
+
+// newTestRoot returns a writable Root, with its Tree and batcher already
+// initialized against client, without actually mounting a fuse connection.
+// The caller is responsible for removing the returned scratch dir.
+func newTestRoot(t testing.TB, client drive.Client) (*Root, string) {
+	tree, err := NewTree(client, nil)
+	if err != nil {
+		t.Fatalf("NewTree: %s", err)
+	}
+	dir, err := ioutil.TempDir("", "shadeScratchTest")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	return &Root{
+		client:     client,
+		tree:       tree,
+		scratchDir: dir,
+		batcher:    newBatcher(client, 4),
+		inodes:     make(map[string]*fs.Inode),
+	}, dir
+}
+
+func removeDir(dir string) {
+	os.RemoveAll(dir)
+}
@@ -0,0 +1,146 @@
+// Package fusefs implements a read-write fuse filesystem over a
+// drive.Client, using github.com/hanwen/go-fuse/v2.
+package fusefs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/asjoyner/shade/drive"
+)
+
+// refreshInterval is how often the Tree polls the backend for changes.
+const refreshInterval = 5 * time.Minute
+
+// defaultTimeout is used for EntryTimeout/AttrTimeout when the client's
+// Config leaves them unset.
+const defaultTimeout = time.Second
+
+// Options controls how a mount behaves; it mirrors the shade command
+// line flags which have historically been independent of any one
+// backend's Config.
+type Options struct {
+	ReadOnly   bool
+	AllowOther bool
+}
+
+// Root is the root of the mounted filesystem.  It owns the Tree, and (for
+// a writable mount) the batcher which uploads dirty files.
+type Root struct {
+	fs.Inode
+	client   drive.Client
+	tree     *Tree
+	readOnly bool
+	batcher  *batcher
+
+	scratchDir   string
+	entryTimeout time.Duration
+	attrTimeout  time.Duration
+
+	im     sync.Mutex           // protects inodes
+	inodes map[string]*fs.Inode // path -> its live *fs.Inode, for notifications
+}
+
+// New mounts client's content at mountpoint and begins serving requests.
+// It blocks until the Tree's initial listing completes, then returns a
+// *fuse.Server the caller can Wait() on or Unmount().
+func New(client drive.Client, mountpoint string, opts Options) (*fuse.Server, error) {
+	conf := client.GetConfig()
+	entryTimeout := conf.EntryTimeout
+	if entryTimeout == 0 {
+		entryTimeout = defaultTimeout
+	}
+	attrTimeout := conf.AttrTimeout
+	if attrTimeout == 0 {
+		attrTimeout = defaultTimeout
+	}
+
+	root := &Root{
+		client:       client,
+		readOnly:     opts.ReadOnly,
+		entryTimeout: entryTimeout,
+		attrTimeout:  attrTimeout,
+		inodes:       make(map[string]*fs.Inode),
+	}
+
+	tree, err := NewTree(client, time.NewTicker(refreshInterval))
+	if err != nil {
+		return nil, fmt.Errorf("initializing tree: %s", err)
+	}
+	tree.OnChange(root.notify)
+	root.tree = tree
+
+	if !opts.ReadOnly {
+		dir, err := ioutil.TempDir("", "shadeScratch")
+		if err != nil {
+			return nil, fmt.Errorf("creating scratch dir: %s", err)
+		}
+		root.scratchDir = dir
+		root.batcher = newBatcher(client, 4)
+	}
+
+	// go-fuse answers ReadDirPlus requests straight from Readdir, so a
+	// directory listing already returns attrs for every entry in one round
+	// trip; there's no separate opt-in required the way there was under
+	// bazil.org/fuse.
+	fsOpts := &fs.Options{
+		EntryTimeout: &entryTimeout,
+		AttrTimeout:  &attrTimeout,
+		MountOptions: fuse.MountOptions{
+			AllowOther: opts.AllowOther,
+			FsName:     "shade",
+			Name:       "shade",
+		},
+	}
+	server, err := fs.Mount(mountpoint, root, fsOpts)
+	if err != nil {
+		return nil, fmt.Errorf("mounting %q: %s", mountpoint, err)
+	}
+	return server, nil
+}
+
+// notify is registered with the Tree as its OnChange callback.  It pushes
+// a kernel-cache invalidation for path, so a change discovered by a
+// periodic Refresh (rather than a write through this mount) is visible to
+// readers without them needing to stat() to find out.
+func (r *Root) notify(path string) {
+	r.im.Lock()
+	defer r.im.Unlock()
+	if inode, ok := r.inodes[path]; ok {
+		inode.NotifyContent(0, 0)
+	}
+	dir, name := splitPath(path)
+	if parent, ok := r.inodes[dir]; ok {
+		parent.NotifyEntry(name)
+	}
+}
+
+// track records the live *fs.Inode for path, so a later Refresh can target
+// it with notify.
+func (r *Root) track(path string, inode *fs.Inode) {
+	r.im.Lock()
+	defer r.im.Unlock()
+	r.inodes[path] = inode
+}
+
+// retarget re-keys the live *fs.Inode tracked under oldPath to newPath
+// after a successful rename, and updates its shadeNode's path so its
+// future Getattr/Open/etc. calls resolve the right Tree entry.
+func (r *Root) retarget(oldPath, newPath string) {
+	r.im.Lock()
+	defer r.im.Unlock()
+	inode, ok := r.inodes[oldPath]
+	if !ok {
+		return
+	}
+	delete(r.inodes, oldPath)
+	r.inodes[newPath] = inode
+	if n, ok := inode.Operations().(*shadeNode); ok {
+		n.path = newPath
+	}
+}
@@ -0,0 +1,261 @@
+package fusefs
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/asjoyner/shade"
+)
+
+// chunkSize is the size, in bytes, a dirty file is split into at flush
+// time before each piece is handed to PutChunk.
+const chunkSize = 4 << 20 // 4MiB
+
+// fileHandle is an open file descriptor.  For a writable mount, any write
+// lands in a local scratch file; the scratch file is chunked and uploaded
+// by commit, which runs on Flush and Fsync.
+type fileHandle struct {
+	root *Root
+	path string
+
+	mu      sync.Mutex
+	scratch *os.File
+	dirty   bool
+}
+
+var (
+	_ fs.FileReader  = (*fileHandle)(nil)
+	_ fs.FileWriter  = (*fileHandle)(nil)
+	_ fs.FileFlusher = (*fileHandle)(nil)
+	_ fs.FileFsyncer = (*fileHandle)(nil)
+)
+
+// Read serves dest from the scratch file, if this handle has opened one,
+// otherwise by assembling the committed shade.File's chunks.
+func (h *fileHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.scratch != nil {
+		n, err := h.scratch.ReadAt(dest, off)
+		if err != nil && err != io.EOF {
+			return nil, syscall.EIO
+		}
+		return fuse.ReadResultData(dest[:n]), 0
+	}
+
+	node, err := h.root.tree.NodeByPath(h.path)
+	if err != nil {
+		return nil, syscall.ENOENT
+	}
+	file, err := h.root.tree.FileByNode(node)
+	if err != nil {
+		return nil, syscall.EIO
+	}
+	var contents []byte
+	for _, sha := range file.Chunks {
+		chunk, err := h.root.client.GetChunk(sha)
+		if err != nil {
+			return nil, syscall.EIO
+		}
+		contents = append(contents, chunk...)
+	}
+	if off > int64(len(contents)) {
+		off = int64(len(contents))
+	}
+	end := off + int64(len(dest))
+	if end > int64(len(contents)) {
+		end = int64(len(contents))
+	}
+	n := copy(dest, contents[off:end])
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+// Write stages data at off in the handle's scratch file, opening one on
+// the Root's scratchDir if this is the first write through this handle.
+// The first write through a handle opened via Open (as opposed to Create)
+// seeds the scratch file with the path's already-committed content, so a
+// write that doesn't cover the whole file preserves the bytes it doesn't
+// touch.  For an overlay mount (see drive/overlay) this is how a
+// lower-only file is copied up: the seed is read through GetChunk, which
+// falls through upper then lower, into the local scratch file, and upper
+// receives the file's chunks and shade.File only when commit runs.
+func (h *fileHandle) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
+	if h.root.readOnly {
+		return 0, syscall.EROFS
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.scratch == nil {
+		scratch, err := os.OpenFile(h.root.scratchPath(h.path), os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return 0, syscall.EIO
+		}
+		if err := h.root.seedScratch(scratch, h.path); err != nil {
+			scratch.Close()
+			return 0, syscall.EIO
+		}
+		h.scratch = scratch
+	}
+	n, err := h.scratch.WriteAt(data, off)
+	if err != nil {
+		return 0, syscall.EIO
+	}
+	h.dirty = true
+	return uint32(n), 0
+}
+
+// Flush commits any dirty scratch data to the backend.
+func (h *fileHandle) Flush(ctx context.Context) syscall.Errno {
+	if err := h.commit(); err != nil {
+		return syscall.EIO
+	}
+	return 0
+}
+
+// Fsync commits any dirty scratch data to the backend.
+func (h *fileHandle) Fsync(ctx context.Context, flags uint32) syscall.Errno {
+	if err := h.commit(); err != nil {
+		return syscall.EIO
+	}
+	return 0
+}
+
+// commit splits the scratch file into chunks, enqueues them (and the
+// resulting shade.File) to the Root's batcher, and updates the Tree in
+// place so subsequent reads see the new content without waiting for a
+// periodic Refresh.
+func (h *fileHandle) commit() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.scratch == nil || !h.dirty {
+		return nil
+	}
+	info, err := h.scratch.Stat()
+	if err != nil {
+		return fmt.Errorf("stat scratch file for %q: %s", h.path, err)
+	}
+	if _, err := h.scratch.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	var chunkShas [][]byte
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(h.scratch, buf)
+		if n > 0 {
+			chunk := append([]byte(nil), buf[:n]...)
+			sum := sha256.Sum256(chunk)
+			if err := h.root.batcher.putChunk(sum[:], chunk); err != nil {
+				return fmt.Errorf("uploading chunk of %q: %s", h.path, err)
+			}
+			chunkShas = append(chunkShas, sum[:])
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading scratch file for %q: %s", h.path, err)
+		}
+	}
+
+	modified := time.Now()
+	file := &shade.File{
+		Filename:     h.path,
+		Filesize:     info.Size(),
+		ModifiedTime: modified,
+		Chunks:       chunkShas,
+	}
+	fj, err := file.ToJSON()
+	if err != nil {
+		return err
+	}
+	fileSha := sha256.Sum256(fj)
+	if err := h.root.batcher.putFile(fileSha[:], fj); err != nil {
+		return fmt.Errorf("uploading metadata of %q: %s", h.path, err)
+	}
+
+	node := Node{
+		Filename:     file.Filename,
+		Filesize:     uint64(file.Filesize),
+		ModifiedTime: modified,
+		Sha256sum:    fileSha[:],
+	}
+	// Matches Tree.Refresh's existing collision rule: the write that
+	// reports the later ModifiedTime wins.  The check and the update are
+	// made under one hold of the Tree's lock, so concurrent commits to the
+	// same path cannot let an older write replace a newer one.
+	t := h.root.tree
+	t.nm.Lock()
+	if existing, ok := t.nodes[node.Filename]; ok && existing.ModifiedTime.After(node.ModifiedTime) {
+		t.nm.Unlock()
+		h.dirty = false
+		return nil
+	}
+	t.nodes[node.Filename] = node
+	t.addParents(node.Filename)
+	t.nm.Unlock()
+	t.notify(node.Filename)
+	h.dirty = false
+	return nil
+}
+
+// scratchPath returns the scratch file path used to stage dirty writes to
+// p, keyed by its inode so concurrent handles for the same path share one
+// staging file.
+func (r *Root) scratchPath(p string) string {
+	return path.Join(r.scratchDir, fmt.Sprintf("%d", Inode(p)))
+}
+
+// seedScratch copies p's already-committed content into scratch, if p has
+// a committed Node; a brand new path has nothing to seed.
+func (r *Root) seedScratch(scratch *os.File, p string) error {
+	node, err := r.tree.NodeByPath(p)
+	if err != nil || node.Synthetic() {
+		return nil
+	}
+	file, err := r.tree.FileByNode(node)
+	if err != nil {
+		return fmt.Errorf("seeding scratch for %q: %s", p, err)
+	}
+	var off int64
+	for _, sha := range file.Chunks {
+		chunk, err := r.client.GetChunk(sha)
+		if err != nil {
+			return fmt.Errorf("seeding scratch for %q: %s", p, err)
+		}
+		if _, err := scratch.WriteAt(chunk, off); err != nil {
+			return fmt.Errorf("seeding scratch for %q: %s", p, err)
+		}
+		off += int64(len(chunk))
+	}
+	return nil
+}
+
+// truncate resets the handle's scratch file to empty and marks it dirty,
+// opening one first if none exists yet.  It implements the Size-0 case of
+// shadeNode.Setattr (a truncating open).
+func (h *fileHandle) truncate() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.scratch == nil {
+		scratch, err := os.OpenFile(h.root.scratchPath(h.path), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		h.scratch = scratch
+	} else if err := h.scratch.Truncate(0); err != nil {
+		return err
+	}
+	h.dirty = true
+	return nil
+}
@@ -0,0 +1,160 @@
+package fusefs
+
+import (
+	"context"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// shadeNode represents a single file or (synthetic) directory in the
+// Tree, identified by its full path.
+type shadeNode struct {
+	fs.Inode
+	root *Root
+	path string
+}
+
+var (
+	_ fs.NodeGetattrer = (*shadeNode)(nil)
+	_ fs.NodeLookuper  = (*shadeNode)(nil)
+	_ fs.NodeReaddirer = (*shadeNode)(nil)
+	_ fs.NodeOpener    = (*shadeNode)(nil)
+	_ fs.NodeCreater   = (*shadeNode)(nil)
+	_ fs.NodeMkdirer   = (*shadeNode)(nil)
+	_ fs.NodeUnlinker  = (*shadeNode)(nil)
+	_ fs.NodeRenamer   = (*shadeNode)(nil)
+	_ fs.NodeSetattrer = (*shadeNode)(nil)
+
+	_ fs.NodeGetattrer = (*Root)(nil)
+	_ fs.NodeLookuper  = (*Root)(nil)
+	_ fs.NodeReaddirer = (*Root)(nil)
+	_ fs.NodeCreater   = (*Root)(nil)
+	_ fs.NodeMkdirer   = (*Root)(nil)
+	_ fs.NodeUnlinker  = (*Root)(nil)
+	_ fs.NodeRenamer   = (*Root)(nil)
+)
+
+// fillDirAttr fills a with the standard attributes of a synthetic
+// directory.
+func fillDirAttr(a *fuse.Attr) {
+	a.Mode = syscall.S_IFDIR | 0755
+}
+
+// fillFileAttr fills a with the attributes of node, a non-synthetic Node.
+func fillFileAttr(a *fuse.Attr, node Node) {
+	a.Mode = syscall.S_IFREG | 0644
+	a.Size = node.Filesize
+	a.Mtime = uint64(node.ModifiedTime.Unix())
+}
+
+// childPath joins a directory path and a child name the way Tree stores
+// Filenames: no leading slash below the root, and no trailing slash.
+func childPath(dir, name string) string {
+	if dir == "/" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// splitPath is the inverse of childPath: it returns the parent directory
+// path and base name of p.
+func splitPath(p string) (dir, name string) {
+	if !strings.Contains(p, "/") {
+		return "/", p
+	}
+	d, f := path.Split(p)
+	return strings.TrimSuffix(d, "/"), f
+}
+
+// lookup implements the shared Lookup logic for both Root and shadeNode.
+func lookup(root *Root, dirPath, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	p := childPath(dirPath, name)
+	node, err := root.tree.NodeByPath(p)
+	if err != nil {
+		return nil, syscall.ENOENT
+	}
+	out.SetEntryTimeout(root.entryTimeout)
+	out.SetAttrTimeout(root.attrTimeout)
+	if node.Synthetic() {
+		fillDirAttr(&out.Attr)
+	} else {
+		fillFileAttr(&out.Attr, node)
+	}
+	stable := fs.StableAttr{Mode: out.Attr.Mode & syscall.S_IFMT, Ino: Inode(p)}
+	child := root.NewInode(context.Background(), &shadeNode{root: root, path: p}, stable)
+	root.track(p, child)
+	return child, 0
+}
+
+// readdir implements the shared ReadDirPlus-backed Readdir logic for both
+// Root and shadeNode: every entry's type is resolved from the Tree in one
+// pass, so the kernel does not need a follow-up Lookup/Getattr per entry.
+func readdir(root *Root, dirPath string) (fs.DirStream, syscall.Errno) {
+	dirNode, err := root.tree.NodeByPath(dirPath)
+	if err != nil {
+		return nil, syscall.ENOENT
+	}
+	var entries []fuse.DirEntry
+	for name := range dirNode.Children {
+		child, err := root.tree.NodeByPath(childPath(dirPath, name))
+		if err != nil {
+			continue
+		}
+		mode := uint32(syscall.S_IFREG)
+		if child.Synthetic() {
+			mode = syscall.S_IFDIR
+		}
+		entries = append(entries, fuse.DirEntry{Name: name, Ino: Inode(child.Filename), Mode: mode})
+	}
+	return fs.NewListDirStream(entries), 0
+}
+
+// Getattr implements fs.NodeGetattrer for the root directory.
+func (r *Root) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	fillDirAttr(&out.Attr)
+	return 0
+}
+
+// Lookup implements fs.NodeLookuper for the root directory.
+func (r *Root) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	return lookup(r, "/", name, out)
+}
+
+// Readdir implements fs.NodeReaddirer for the root directory.
+func (r *Root) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	return readdir(r, "/")
+}
+
+// Getattr implements fs.NodeGetattrer for a file or synthetic directory.
+func (n *shadeNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	node, err := n.root.tree.NodeByPath(n.path)
+	if err != nil {
+		return syscall.ENOENT
+	}
+	if node.Synthetic() {
+		fillDirAttr(&out.Attr)
+	} else {
+		fillFileAttr(&out.Attr, node)
+	}
+	return 0
+}
+
+// Lookup implements fs.NodeLookuper for a synthetic directory.
+func (n *shadeNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	return lookup(n.root, n.path, name, out)
+}
+
+// Readdir implements fs.NodeReaddirer for a synthetic directory.
+func (n *shadeNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	return readdir(n.root, n.path)
+}
+
+// Open returns a fileHandle for reading (and, on a writable mount,
+// writing) the contents of the file.
+func (n *shadeNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	return &fileHandle{root: n.root, path: n.path}, 0, 0
+}
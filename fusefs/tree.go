@@ -1,9 +1,11 @@
 package fusefs
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
 	"path"
 	"strings"
@@ -51,6 +53,33 @@ type Tree struct {
 	nodes  map[string]Node // full path to node
 	nm     sync.RWMutex    // protects nodes
 	debug  bool
+	// manifestID is the client's ManifestID() as of the last Refresh that
+	// completed a full list-and-fetch cycle.  It is nil until a client
+	// implementing drive.ManifestIDer has successfully reported one.
+	manifestID []byte
+	// onChange, if set, is called with the path of every node that Refresh
+	// discovers as new or updated, and every node SetNode/RemoveNode
+	// mutate directly.  fusefs uses it to push kernel cache invalidations
+	// without waiting for a stat probe.
+	onChange func(path string)
+}
+
+// OnChange registers f to be called with the path of any node Refresh,
+// SetNode, or RemoveNode add, update, or delete.  Only one callback may be
+// registered; a later call replaces the previous one.
+func (t *Tree) OnChange(f func(path string)) {
+	t.nm.Lock()
+	defer t.nm.Unlock()
+	t.onChange = f
+}
+
+func (t *Tree) notify(path string) {
+	t.nm.RLock()
+	f := t.onChange
+	t.nm.RUnlock()
+	if f != nil {
+		f(path)
+	}
 }
 
 // NewTree queries client to discover all the shade.File(s).  It returns a Tree
@@ -88,6 +117,45 @@ func (t *Tree) NodeByPath(p string) (Node, error) {
 	return Node{}, fmt.Errorf("no such node: %q", p)
 }
 
+// SetNode inserts or overwrites the node at its Filename, and ensures its
+// parent directories exist.  It is used both by Refresh, and by the write
+// path once a batch of writes has committed, to make the new shade.File
+// visible immediately rather than waiting for the next periodic Refresh.
+func (t *Tree) SetNode(n Node) {
+	t.nm.Lock()
+	t.nodes[n.Filename] = n
+	t.addParents(n.Filename)
+	t.nm.Unlock()
+	t.notify(n.Filename)
+}
+
+// RemoveNode deletes the node at filename, and its entry in its parent's
+// Children map.
+func (t *Tree) RemoveNode(filename string) {
+	t.nm.Lock()
+	delete(t.nodes, filename)
+	dir, f := path.Split(filename)
+	if dir == "" {
+		dir = "/"
+	} else {
+		dir = strings.TrimSuffix(dir, "/")
+	}
+	if parent, ok := t.nodes[dir]; ok {
+		delete(parent.Children, f)
+	}
+	t.nm.Unlock()
+	t.notify(filename)
+}
+
+// Inode returns a stable fuse inode number for filename.  It is derived
+// from the path itself, rather than assigned sequentially, so it survives
+// across a Refresh that rebuilds the Node for the same file.
+func Inode(filename string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(filename))
+	return h.Sum64()
+}
+
 func unmarshalChunk(fj, sha []byte) (*shade.File, error) {
 	file := &shade.File{}
 	if err := json.Unmarshal(fj, file); err != nil {
@@ -137,9 +205,31 @@ func (t *Tree) GetChunk(sha256sum []byte) {
 }
 
 // Refresh updates the cached view of the Tree by calling ListFiles and
-// processing the result.
+// processing the result.  If the client implements drive.ManifestIDer, a
+// cheap ManifestID() call is tried first; when it reports the same value
+// seen on the last full refresh, Refresh returns immediately without
+// listing or fetching anything.
 func (t *Tree) Refresh() error {
 	t.log("Begining cache refresh cycle.")
+	var newManifestID []byte
+	if mi, ok := t.client.(drive.ManifestIDer); ok {
+		id, err := mi.ManifestID()
+		switch {
+		case err == nil:
+			t.nm.RLock()
+			unchanged := t.manifestID != nil && bytes.Equal(id, t.manifestID)
+			t.nm.RUnlock()
+			if unchanged {
+				t.log("manifest unchanged since last refresh; skipping")
+				return nil
+			}
+			newManifestID = id
+		case err == drive.ErrNotImplemented:
+			// fall through to the list-and-fetch path below
+		default:
+			return fmt.Errorf("%q ManifestID(): %s", t.client.GetConfig().Provider, err)
+		}
+	}
 	// key is a string([]byte) representation of the file's SHA2
 	knownNodes := make(map[string]bool)
 	newFiles, err := t.client.ListFiles()
@@ -183,8 +273,14 @@ func (t *Tree) Refresh() error {
 		t.nodes[node.Filename] = node
 		t.addParents(node.Filename)
 		t.nm.Unlock()
+		t.notify(node.Filename)
 		knownNodes[string(sha256sum)] = true
 	}
+	if newManifestID != nil {
+		t.nm.Lock()
+		t.manifestID = newManifestID
+		t.nm.Unlock()
+	}
 	t.log(fmt.Sprintf("Refresh complete with %d file(s).", len(knownNodes)))
 	return nil
 }
@@ -0,0 +1,86 @@
+package fusefs
+
+import (
+	"crypto/sha256"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/asjoyner/shade"
+	"github.com/asjoyner/shade/drive"
+)
+
+// manifestClient wraps a fakeClient to implement drive.ManifestIDer, and
+// counts GetChunk calls so tests can assert Refresh's fast path actually
+// skips fetching chunks.  ManifestID is computed the way the request
+// suggests a backend without native support could: sort and hash the
+// ListFiles result.
+type manifestClient struct {
+	*fakeClient
+	getChunkCalls int64
+}
+
+func newManifestClient() *manifestClient {
+	return &manifestClient{fakeClient: newFakeClient()}
+}
+
+func (c *manifestClient) GetChunk(sha256sum []byte) ([]byte, error) {
+	atomic.AddInt64(&c.getChunkCalls, 1)
+	return c.fakeClient.GetChunk(sha256sum)
+}
+
+func (c *manifestClient) ManifestID() ([]byte, error) {
+	shas, err := c.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+	strs := make([]string, len(shas))
+	for i, s := range shas {
+		strs[i] = string(s)
+	}
+	sort.Strings(strs)
+	h := sha256.New()
+	for _, s := range strs {
+		h.Write([]byte(s))
+	}
+	return h.Sum(nil), nil
+}
+
+var _ drive.ManifestIDer = (*manifestClient)(nil)
+
+// TestRefreshSkipsUnchangedManifest verifies that once a Tree has seen a
+// client's ManifestID, a subsequent Refresh with no underlying changes
+// returns without issuing any GetChunk calls.
+func TestRefreshSkipsUnchangedManifest(t *testing.T) {
+	client := newManifestClient()
+	f := &shade.File{
+		Filename:     "idle.txt",
+		ModifiedTime: time.Unix(0, 0),
+	}
+	fj, err := f.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON: %s", err)
+	}
+	sum := shaSum(fj)
+	if err := client.PutFile(sum, fj); err != nil {
+		t.Fatalf("PutFile: %s", err)
+	}
+
+	tree, err := NewTree(client, nil)
+	if err != nil {
+		t.Fatalf("NewTree: %s", err)
+	}
+	if _, err := tree.NodeByPath("idle.txt"); err != nil {
+		t.Fatalf("NodeByPath: %s", err)
+	}
+
+	before := atomic.LoadInt64(&client.getChunkCalls)
+	if err := tree.Refresh(); err != nil {
+		t.Fatalf("Refresh: %s", err)
+	}
+	after := atomic.LoadInt64(&client.getChunkCalls)
+	if after != before {
+		t.Fatalf("idle Refresh() issued %d GetChunk call(s), want 0", after-before)
+	}
+}
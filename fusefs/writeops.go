@@ -0,0 +1,173 @@
+package fusefs
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+
+	"github.com/asjoyner/shade/drive"
+)
+
+// create implements the shared Create logic for both Root and shadeNode:
+// it opens a scratch file for name, keyed by its (not-yet-existing) inode,
+// and returns a node and handle ready to accept Writes.
+func create(root *Root, dirPath, name string, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, syscall.Errno) {
+	if root.readOnly {
+		return nil, nil, syscall.EROFS
+	}
+	p := childPath(dirPath, name)
+	scratch, err := os.OpenFile(root.scratchPath(p), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, nil, syscall.EIO
+	}
+	out.SetEntryTimeout(root.entryTimeout)
+	out.SetAttrTimeout(root.attrTimeout)
+	fillFileAttr(&out.Attr, Node{Filename: p, ModifiedTime: time.Now()})
+	stable := fs.StableAttr{Mode: syscall.S_IFREG, Ino: Inode(p)}
+	child := root.NewInode(context.Background(), &shadeNode{root: root, path: p}, stable)
+	root.track(p, child)
+	return child, &fileHandle{root: root, path: p, scratch: scratch, dirty: true}, 0
+}
+
+// mkdir implements the shared Mkdir logic for both Root and shadeNode.
+func mkdir(root *Root, dirPath, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if root.readOnly {
+		return nil, syscall.EROFS
+	}
+	p := childPath(dirPath, name)
+	root.tree.SetNode(Node{Filename: p, Children: make(map[string]bool)})
+	out.SetEntryTimeout(root.entryTimeout)
+	out.SetAttrTimeout(root.attrTimeout)
+	fillDirAttr(&out.Attr)
+	stable := fs.StableAttr{Mode: syscall.S_IFDIR, Ino: Inode(p)}
+	child := root.NewInode(context.Background(), &shadeNode{root: root, path: p}, stable)
+	root.track(p, child)
+	return child, 0
+}
+
+// unlink implements the shared Remove/Unlink logic for both Root and
+// shadeNode.  If the backend implements drive.Deleter (e.g. drive/overlay),
+// it is told about the deletion too, so it persists past the next Refresh
+// instead of only disappearing from the in-memory Tree.
+func unlink(root *Root, dirPath, name string) syscall.Errno {
+	if root.readOnly {
+		return syscall.EROFS
+	}
+	p := childPath(dirPath, name)
+	if deleter, ok := root.client.(drive.Deleter); ok {
+		if err := deleter.Delete(p); err != nil {
+			return syscall.EIO
+		}
+	}
+	root.tree.RemoveNode(p)
+	return 0
+}
+
+// rename implements the shared Rename logic for both Root and shadeNode.
+// oldParent is the directory (Root or shadeNode) the kernel invoked
+// Rename on; it's needed, alongside newParent, to move the live go-fuse
+// Inode in place via MvChild, so a handle opened before the rename keeps
+// referring to the same inode instead of getting ENOENT once Tree forgets
+// oldPath.
+func rename(root *Root, oldParent fs.InodeEmbedder, dirPath, name string, newParent fs.InodeEmbedder, newName string) syscall.Errno {
+	if root.readOnly {
+		return syscall.EROFS
+	}
+	var newDirPath string
+	switch p := newParent.(type) {
+	case *Root:
+		newDirPath = "/"
+	case *shadeNode:
+		newDirPath = p.path
+	default:
+		return syscall.EIO
+	}
+	oldPath := childPath(dirPath, name)
+	newPath := childPath(newDirPath, newName)
+	n, err := root.tree.NodeByPath(oldPath)
+	if err != nil {
+		return syscall.ENOENT
+	}
+	n.Filename = newPath
+	root.tree.RemoveNode(oldPath)
+	root.tree.SetNode(n)
+
+	if !oldParent.EmbeddedInode().MvChild(name, newParent.EmbeddedInode(), newName, true) {
+		return syscall.EIO
+	}
+	root.retarget(oldPath, newPath)
+	return 0
+}
+
+// Create implements fs.NodeCreater for the root directory.
+func (r *Root) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	child, fh, errno := create(r, "/", name, out)
+	return child, fh, 0, errno
+}
+
+// Mkdir implements fs.NodeMkdirer for the root directory.
+func (r *Root) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	return mkdir(r, "/", name, out)
+}
+
+// Unlink implements fs.NodeUnlinker for the root directory.
+func (r *Root) Unlink(ctx context.Context, name string) syscall.Errno {
+	return unlink(r, "/", name)
+}
+
+// Rename implements fs.NodeRenamer for the root directory.
+func (r *Root) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	return rename(r, r, "/", name, newParent, newName)
+}
+
+// Create implements fs.NodeCreater for a synthetic directory.
+func (n *shadeNode) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	child, fh, errno := create(n.root, n.path, name, out)
+	return child, fh, 0, errno
+}
+
+// Mkdir implements fs.NodeMkdirer for a synthetic directory.
+func (n *shadeNode) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	return mkdir(n.root, n.path, name, out)
+}
+
+// Unlink implements fs.NodeUnlinker for a synthetic directory.
+func (n *shadeNode) Unlink(ctx context.Context, name string) syscall.Errno {
+	return unlink(n.root, n.path, name)
+}
+
+// Rename implements fs.NodeRenamer for a synthetic directory.
+func (n *shadeNode) Rename(ctx context.Context, name string, newParent fs.InodeEmbedder, newName string, flags uint32) syscall.Errno {
+	return rename(n.root, n, n.path, name, newParent, newName)
+}
+
+// Setattr handles attribute changes.  The only one shade acts on is a
+// truncating open (the kernel reports it as a Setattr with Size 0): it
+// resets the open handle's scratch file to empty and marks it dirty, so
+// the next Write/Flush cycle re-chunks from a clean slate instead of
+// copying-up content that's about to be discarded anyway.  Everything else
+// is a no-op; Setattr just reports the node's current attributes.
+func (n *shadeNode) Setattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	if n.root.readOnly {
+		return syscall.EROFS
+	}
+	if size, ok := in.GetSize(); ok && size == 0 {
+		if h, ok := f.(*fileHandle); ok {
+			if err := h.truncate(); err != nil {
+				return syscall.EIO
+			}
+		}
+	}
+	if node, err := n.root.tree.NodeByPath(n.path); err == nil {
+		if node.Synthetic() {
+			fillDirAttr(&out.Attr)
+		} else {
+			fillFileAttr(&out.Attr, node)
+		}
+	}
+	return 0
+}